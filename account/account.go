@@ -0,0 +1,78 @@
+// Package account holds the Account record shape and identifier parsing
+// shared by the ingest and lookup Lambdas.
+package account
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+)
+
+// TableName is the single-table DynamoDB table backing every handler.
+const TableName = "Breaches"
+
+// HashPrefixIndexName is the GSI used for k-anonymity range lookups:
+// partition key HashPrefix (first 5 hex chars of SHA1Hash), sort key
+// SHA1Hash.
+const HashPrefixIndexName = "HashPrefixIndex"
+
+// HashPrefixLength is the number of leading hex characters of SHA1Hash
+// exposed to callers, mirroring the HIBP range API.
+const HashPrefixLength = 5
+
+// Account is the item persisted for each breached identifier. Type reflects
+// the concrete Kind of the Identifier it was built from, so downstream
+// queries can filter by identifier type.
+type Account struct {
+	PK         string
+	SK         string
+	Type       string
+	Account    string
+	Breaches   []string
+	SHA1Hash   string
+	HashPrefix string
+}
+
+// SHA1Hash returns the uppercase hex SHA-1 digest of the lowercased account
+// identifier, matching the HIBP k-anonymity convention, plus its
+// HashPrefixLength-char prefix.
+func SHA1Hash(account string) (hash string, prefix string) {
+	sum := sha1.Sum([]byte(strings.ToLower(account)))
+	hash = strings.ToUpper(hex.EncodeToString(sum[:]))
+	return hash, hash[:HashPrefixLength]
+}
+
+// MapToAccounts parses each raw identifier according to kind (or
+// auto-detects it when kind is empty) and builds the Account record that
+// will be written for it, including the SHA1Hash and HashPrefix attributes
+// used by the k-anonymity GSI.
+func MapToAccounts(rawAccounts []string, kind Kind) ([]Account, error) {
+	accounts := make([]Account, 0, len(rawAccounts))
+	for _, raw := range rawAccounts {
+		id, err := ParseIdentifier(raw, kind)
+		if err != nil {
+			return []Account{}, err
+		}
+		hash, prefix := SHA1Hash(id.Account())
+		accounts = append(accounts, Account{
+			PK:         id.PartitionKey(),
+			SK:         id.SortKey(),
+			Type:       string(id.Kind()),
+			Account:    id.Account(),
+			Breaches:   make([]string, 0),
+			SHA1Hash:   hash,
+			HashPrefix: prefix,
+		})
+	}
+	return accounts, nil
+}
+
+// Contains reports whether str is present in arr.
+func Contains(arr []string, str string) bool {
+	for _, el := range arr {
+		if el == str {
+			return true
+		}
+	}
+	return false
+}
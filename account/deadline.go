@@ -0,0 +1,25 @@
+package account
+
+import (
+	"context"
+	"time"
+)
+
+// ResponseDeadlineBuffer is reserved off a Lambda's own deadline so the
+// handler has time to marshal and return a clean response instead of being
+// killed mid-call.
+const ResponseDeadlineBuffer = 500 * time.Millisecond
+
+// DeadlineContext derives a child context whose deadline is
+// ResponseDeadlineBuffer before ctx's deadline. A single cancel channel
+// (ctx.Done()) is then closed once that deadline arrives, and every
+// in-flight DynamoDB call and backoff sleep selects on it so a handler can
+// abort promptly and report partial progress. If ctx has no deadline, it's
+// returned as a plain cancellable context.
+func DeadlineContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, deadline.Add(-ResponseDeadlineBuffer))
+}
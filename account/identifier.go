@@ -0,0 +1,212 @@
+package account
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Kind identifies the concrete type of account identifier an Account was
+// built from. It is stored verbatim in the Account.Type attribute so
+// downstream queries can filter by identifier type.
+type Kind string
+
+const (
+	KindEmail    Kind = "EMAIL"
+	KindPhone    Kind = "PHONE"
+	KindUsername Kind = "USERNAME"
+)
+
+// Identifier is an account identifier that can be persisted as an Account.
+// Email, Phone and Username are the concrete kinds.
+type Identifier interface {
+	PartitionKey() string
+	SortKey() string
+	Account() string
+	Kind() Kind
+}
+
+// ParseIdentifier parses raw as the given kind. An empty kind auto-detects
+// it: strings containing "@" are parsed as emails, E.164-looking strings
+// are parsed as phone numbers, and everything else is treated as a
+// username. Email is checked first so an address with an all-digit,
+// "+"-prefixed local-part (e.g. "+14155552671@example.com") isn't
+// misdetected as a phone number with the domain silently discarded.
+func ParseIdentifier(raw string, kind Kind) (Identifier, error) {
+	switch kind {
+	case KindEmail:
+		return NewEmail(raw)
+	case KindPhone:
+		return NewPhone(raw)
+	case KindUsername:
+		return newUsernameFromRaw(raw)
+	case "":
+		return detectIdentifier(raw)
+	default:
+		return nil, fmt.Errorf("unknown identifier kind: %s", kind)
+	}
+}
+
+func detectIdentifier(raw string) (Identifier, error) {
+	if strings.Contains(raw, "@") {
+		if email, err := NewEmail(raw); err == nil {
+			return email, nil
+		}
+	}
+	if strings.HasPrefix(strings.TrimSpace(raw), "+") {
+		if phone, err := NewPhone(raw); err == nil {
+			return phone, nil
+		}
+	}
+	// Auto-detected usernames have no signal that a "/" is a site
+	// separator rather than part of the handle itself, so they're left
+	// site-agnostic; only the explicit KindUsername path (where the
+	// caller opted into the convention) splits on it.
+	return NewUsername(raw, "")
+}
+
+// newUsernameFromRaw splits an explicit-Kind "site/name" raw identifier
+// into its site scope and name. Without a "/", raw is treated as a
+// site-agnostic username. This is how a per-account site scope reaches
+// NewUsername from the Accounts []string the handler actually receives.
+func newUsernameFromRaw(raw string) (Username, error) {
+	if idx := strings.Index(raw, "/"); idx >= 0 {
+		return NewUsername(raw[idx+1:], raw[:idx])
+	}
+	return NewUsername(raw, "")
+}
+
+// Email is an account identifier scoped to the EMAIL# namespace.
+type Email struct {
+	Domain string
+	Alias  string
+}
+
+// NewEmail validates emailStr with net/mail.ParseAddress, the same
+// RFC 5322 parser used by the ACME contact address code, rather than a
+// hand-rolled regex. ParseAddress is permissive of non-ASCII atext, so
+// unicode local-parts and IDN domains are accepted as-is.
+func NewEmail(emailStr string) (Email, error) {
+	addr, err := mail.ParseAddress(emailStr)
+	if err != nil {
+		return Email{}, fmt.Errorf("not a valid email address: %s", emailStr)
+	}
+	parts := strings.SplitN(addr.Address, "@", 2)
+	if len(parts) != 2 {
+		return Email{}, fmt.Errorf("not a valid email address: %s", emailStr)
+	}
+	return Email{
+		Alias:  parts[0],
+		Domain: strings.ToLower(parts[1]),
+	}, nil
+}
+
+func (e Email) Account() string {
+	return fmt.Sprintf("%s@%s", e.Alias, e.Domain)
+}
+
+func (e Email) PartitionKey() string {
+	return fmt.Sprintf("EMAIL#%s", e.Domain)
+}
+
+func (e Email) SortKey() string {
+	return fmt.Sprintf("EMAIL#%s", e.Alias)
+}
+
+func (e Email) Kind() Kind {
+	return KindEmail
+}
+
+// e164Regex matches E.164: a leading "+", a non-zero first digit, and up to
+// 15 digits total.
+var e164Regex = regexp.MustCompile(`^\+[1-9]\d{1,14}$`)
+
+// Phone is an account identifier holding an E.164-normalized phone number,
+// scoped to the PHONE# namespace.
+type Phone struct {
+	Number string
+}
+
+// NewPhone strips common formatting (spaces, hyphens, parens) from raw and
+// validates what's left as E.164.
+func NewPhone(raw string) (Phone, error) {
+	normalized := normalizePhone(raw)
+	if !e164Regex.MatchString(normalized) {
+		return Phone{}, fmt.Errorf("not a valid E.164 phone number: %s", raw)
+	}
+	return Phone{Number: normalized}, nil
+}
+
+func normalizePhone(raw string) string {
+	var b strings.Builder
+	for i, r := range strings.TrimSpace(raw) {
+		switch {
+		case r == '+' && i == 0:
+			b.WriteRune(r)
+		case unicode.IsDigit(r):
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func (p Phone) Account() string {
+	return p.Number
+}
+
+func (p Phone) PartitionKey() string {
+	return fmt.Sprintf("PHONE#%s", p.Number)
+}
+
+func (p Phone) SortKey() string {
+	return fmt.Sprintf("PHONE#%s", p.Number)
+}
+
+func (p Phone) Kind() Kind {
+	return KindPhone
+}
+
+// Username is an account identifier scoped to the USERNAME# namespace, with
+// an optional site to disambiguate the same handle on different services.
+type Username struct {
+	Site string
+	Name string
+}
+
+// NewUsername lowercases name and the optional site scope. site may be
+// empty for a site-agnostic username.
+func NewUsername(name string, site string) (Username, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return Username{}, fmt.Errorf("username must not be empty")
+	}
+	return Username{
+		Site: strings.ToLower(strings.TrimSpace(site)),
+		Name: name,
+	}, nil
+}
+
+func (u Username) Account() string {
+	if u.Site == "" {
+		return u.Name
+	}
+	return fmt.Sprintf("%s/%s", u.Site, u.Name)
+}
+
+func (u Username) PartitionKey() string {
+	site := u.Site
+	if site == "" {
+		site = "global"
+	}
+	return fmt.Sprintf("USERNAME#%s", site)
+}
+
+func (u Username) SortKey() string {
+	return fmt.Sprintf("USERNAME#%s", u.Name)
+}
+
+func (u Username) Kind() Kind {
+	return KindUsername
+}
@@ -0,0 +1,140 @@
+package account
+
+import "testing"
+
+func TestNewEmailUnicodeLocalPart(t *testing.T) {
+	email, err := NewEmail("जॉन@example.com")
+	if err != nil {
+		t.Fatalf("NewEmail returned error: %s", err)
+	}
+	if email.Alias != "जॉन" {
+		t.Errorf("Alias = %q, want %q", email.Alias, "जॉन")
+	}
+	if email.Domain != "example.com" {
+		t.Errorf("Domain = %q, want %q", email.Domain, "example.com")
+	}
+}
+
+func TestNewEmailIDNDomain(t *testing.T) {
+	email, err := NewEmail("user@müller.de")
+	if err != nil {
+		t.Fatalf("NewEmail returned error: %s", err)
+	}
+	if email.Domain != "müller.de" {
+		t.Errorf("Domain = %q, want %q", email.Domain, "müller.de")
+	}
+}
+
+func TestNewEmailInvalid(t *testing.T) {
+	if _, err := NewEmail("not-an-email"); err == nil {
+		t.Error("expected error for address with no @domain")
+	}
+}
+
+func TestNewPhoneNormalizesFormatting(t *testing.T) {
+	cases := []struct {
+		raw  string
+		want string
+	}{
+		{"+1 (415) 555-2671", "+14155552671"},
+		{"+44 20 7946 0958", "+442079460958"},
+		{"+14155552671", "+14155552671"},
+	}
+	for _, c := range cases {
+		phone, err := NewPhone(c.raw)
+		if err != nil {
+			t.Errorf("NewPhone(%q) returned error: %s", c.raw, err)
+			continue
+		}
+		if phone.Number != c.want {
+			t.Errorf("NewPhone(%q).Number = %q, want %q", c.raw, phone.Number, c.want)
+		}
+	}
+}
+
+func TestNewPhoneRejectsInvalid(t *testing.T) {
+	cases := []string{"0123456789", "+0123456789", "not a phone", ""}
+	for _, raw := range cases {
+		if _, err := NewPhone(raw); err == nil {
+			t.Errorf("NewPhone(%q) expected error, got none", raw)
+		}
+	}
+}
+
+func TestParseIdentifierAutoDetect(t *testing.T) {
+	cases := []struct {
+		raw      string
+		wantKind Kind
+	}{
+		{"user@example.com", KindEmail},
+		{"+14155552671", KindPhone},
+		{"CoolHandle", KindUsername},
+	}
+	for _, c := range cases {
+		id, err := ParseIdentifier(c.raw, "")
+		if err != nil {
+			t.Errorf("ParseIdentifier(%q, \"\") returned error: %s", c.raw, err)
+			continue
+		}
+		if id.Kind() != c.wantKind {
+			t.Errorf("ParseIdentifier(%q, \"\").Kind() = %s, want %s", c.raw, id.Kind(), c.wantKind)
+		}
+	}
+}
+
+// TestParseIdentifierPhoneLikeLocalPart guards against auto-detect routing
+// an email whose local-part looks like a phone number (all digits behind a
+// leading "+") to NewPhone, which would silently discard the "@domain"
+// part as non-digit noise.
+func TestParseIdentifierPhoneLikeLocalPart(t *testing.T) {
+	id, err := ParseIdentifier("+14155552671@example.com", "")
+	if err != nil {
+		t.Fatalf("ParseIdentifier returned error: %s", err)
+	}
+	if id.Kind() != KindEmail {
+		t.Errorf("Kind() = %s, want %s", id.Kind(), KindEmail)
+	}
+	if id.Account() != "+14155552671@example.com" {
+		t.Errorf("Account() = %q, want %q", id.Account(), "+14155552671@example.com")
+	}
+}
+
+func TestUsernameLowercasedAndScoped(t *testing.T) {
+	u, err := NewUsername("CoolHandle", "Reddit")
+	if err != nil {
+		t.Fatalf("NewUsername returned error: %s", err)
+	}
+	if u.Account() != "reddit/coolhandle" {
+		t.Errorf("Account() = %q, want %q", u.Account(), "reddit/coolhandle")
+	}
+	if u.PartitionKey() != "USERNAME#reddit" {
+		t.Errorf("PartitionKey() = %q, want %q", u.PartitionKey(), "USERNAME#reddit")
+	}
+	if u.SortKey() != "USERNAME#coolhandle" {
+		t.Errorf("SortKey() = %q, want %q", u.SortKey(), "USERNAME#coolhandle")
+	}
+}
+
+// TestParseIdentifierUsernameSiteScope exercises the site/name split that
+// lets a per-account site scope reach NewUsername from the raw identifier
+// strings ParseIdentifier (and so MapToAccounts) actually receives.
+func TestParseIdentifierUsernameSiteScope(t *testing.T) {
+	id, err := ParseIdentifier("Reddit/CoolHandle", KindUsername)
+	if err != nil {
+		t.Fatalf("ParseIdentifier returned error: %s", err)
+	}
+	if id.PartitionKey() != "USERNAME#reddit" {
+		t.Errorf("PartitionKey() = %q, want %q", id.PartitionKey(), "USERNAME#reddit")
+	}
+	if id.SortKey() != "USERNAME#coolhandle" {
+		t.Errorf("SortKey() = %q, want %q", id.SortKey(), "USERNAME#coolhandle")
+	}
+
+	unscoped, err := ParseIdentifier("CoolHandle", KindUsername)
+	if err != nil {
+		t.Fatalf("ParseIdentifier returned error: %s", err)
+	}
+	if unscoped.PartitionKey() != "USERNAME#global" {
+		t.Errorf("PartitionKey() = %q, want %q", unscoped.PartitionKey(), "USERNAME#global")
+	}
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"regexp"
-	"strings"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -13,72 +16,102 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+
+	"github.com/joshuous/haveibeenbreached/account"
 )
 
 type Response events.APIGatewayProxyResponse
 
 type AddAccountEvent struct {
 	Accounts       []string
+	Kind           account.Kind
 	PathParameters struct {
 		BreachName string
 	}
 }
 
-type Account struct {
-	PK       string
-	SK       string
-	Type     string
-	Account  string
-	Breaches []string
-}
-
 var sess = session.Must(session.NewSessionWithOptions(session.Options{
 	SharedConfigState: session.SharedConfigEnable,
 }))
 var svc = dynamodb.New(sess)
-var tableName = "Breaches"
-var entityType = "Account"
+var tableName = account.TableName
+
+// dynamoBatchWriteLimit is the maximum number of items DynamoDB accepts in a
+// single BatchWriteItem call.
+const dynamoBatchWriteLimit = 25
+
+// maxUnprocessedRetries bounds the exponential backoff loop for
+// UnprocessedItems so a persistently throttled table can't hang the Lambda
+// past its deadline.
+const maxUnprocessedRetries = 8
+
+// defaultBatchWorkers is used when BATCH_WRITE_WORKERS isn't set or isn't a
+// valid positive integer.
+const defaultBatchWorkers = 4
+
+func batchWorkerCount() int {
+	if raw := os.Getenv("BATCH_WRITE_WORKERS"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultBatchWorkers
+}
 
 func Handler(ctx context.Context, event AddAccountEvent) (Response, error) {
+	ctx, cancel := account.DeadlineContext(ctx)
+	defer cancel()
+
 	rawAccounts := event.Accounts
 	breachName := event.PathParameters.BreachName
 
-	accounts, err := mapToAccount(rawAccounts)
+	accounts, err := account.MapToAccounts(rawAccounts, event.Kind)
 	if err != nil {
-		return Response{StatusCode: 400, Body: fmt.Sprintf("Invalid email: %s", err)}, err
+		return Response{StatusCode: 400, Body: fmt.Sprintf("Invalid account identifier: %s", err)}, err
 	}
 
-	accounts, err = setAccountBreaches(accounts, breachName)
+	done, pending, err := setAccountBreaches(ctx, accounts, breachName)
 	if err != nil {
-		return Response{StatusCode: 400, Body: fmt.Sprintf("Invalid email: %s", err)}, err
+		if ctx.Err() != nil {
+			// Nothing has been written yet, so every account is still pending.
+			return deadlineResponse([]string{}, append(accountNames(done), pending...)), nil
+		}
+		return Response{StatusCode: 400, Body: fmt.Sprintf("Error looking up existing breaches: %s", err)}, err
 	}
 
-	attrVals, err := marshalMapToAttributeValues(accounts)
+	done = dedupeAccounts(done)
+
+	attrVals, err := marshalMapToAttributeValues(done)
 	if err != nil {
 		return Response{StatusCode: 400, Body: fmt.Sprintf("Error marshalling new Account: %s", err)}, err
 	}
 
-	for _, attrVal := range attrVals {
-		input := &dynamodb.PutItemInput{
-			Item:      attrVal,
-			TableName: aws.String(tableName),
-		}
-		_, err = svc.PutItem(input)
-		if err != nil {
-			return Response{StatusCode: 400, Body: fmt.Sprintf("Error adding Account %+v to breach: %s", input, err)}, err
-		}
+	failed, batchErr := batchPutAccounts(ctx, attrVals)
+	if ctx.Err() != nil {
+		return deadlineResponse(subtract(accountNames(done), failed), failed), nil
 	}
 
-	numAccounts := len(accounts)
-	body, err := json.Marshal(map[string]interface{}{
-		"message": fmt.Sprintf("Successfully added/updated %d accounts to the %s breach.", numAccounts, breachName),
-	})
+	numAccounts := len(done)
+	numFailed := len(failed)
+	responseBody := map[string]interface{}{
+		"message":           fmt.Sprintf("Successfully added/updated %d of %d accounts to the %s breach.", numAccounts-numFailed, numAccounts, breachName),
+		"failedIdentifiers": failed,
+	}
+	if batchErr != nil {
+		responseBody["error"] = batchErr.Error()
+	}
+	body, err := json.Marshal(responseBody)
 	if err != nil {
 		return Response{StatusCode: 400}, err
 	}
 
+	statusCode := 200
+	if numFailed > 0 {
+		statusCode = 207
+	}
+
 	resp := Response{
-		StatusCode:      200,
+		StatusCode:      statusCode,
 		IsBase64Encoded: false,
 		Body:            string(body),
 		Headers: map[string]string{
@@ -93,83 +126,121 @@ func main() {
 	lambda.Start(Handler)
 }
 
-func mapToAccount(accounts []string) ([]Account, error) {
-	accs := make([]Account, 0, len(accounts))
-	var err error
-
-	for _, account := range accounts {
-		email, emailErr := NewEmail(account)
-		if err != nil {
-			err = emailErr
-			break
-		}
-		newAccount := Account{
-			PK:       email.PartitionKey(),
-			SK:       email.SortKey(),
-			Type:     entityType,
-			Account:  email.Account(),
-			Breaches: make([]string, 0),
-		}
-		accs = append(accs, newAccount)
-	}
-	if err != nil {
-		return []Account{}, err
-	}
-	return accs, nil
-}
-
-func setAccountBreaches(accounts []Account, breachName string) ([]Account, error) {
-	accs := make([]Account, 0, len(accounts))
-	var err error
-
-	for _, account := range accounts {
+// setAccountBreaches fetches each account's existing Breaches list and
+// appends breachName if it isn't already present. If ctx is cancelled or
+// its deadline arrives partway through, it stops immediately and returns
+// the accounts it finished looking up (done) alongside the identifiers of
+// the ones it never got to (pending). None of them has been written yet.
+func setAccountBreaches(ctx context.Context, accounts []account.Account, breachName string) (done []account.Account, pending []string, err error) {
+	for i, acc := range accounts {
 		input := &dynamodb.GetItemInput{
 			TableName: aws.String(tableName),
 			Key: map[string]*dynamodb.AttributeValue{
 				"PK": {
-					S: aws.String(account.PK),
+					S: aws.String(acc.PK),
 				},
 				"SK": {
-					S: aws.String(account.SK),
+					S: aws.String(acc.SK),
 				},
 			},
 		}
-		result, getItemErr := svc.GetItem(input)
+		result, getItemErr := svc.GetItemWithContext(ctx, input)
 		if getItemErr != nil {
-			err = getItemErr
-			break
+			if ctx.Err() != nil {
+				return done, accountNames(accounts[i:]), ctx.Err()
+			}
+			return nil, nil, getItemErr
 		}
 		if result.Item != nil {
-			existingAcc := &Account{}
+			existingAcc := &account.Account{}
 			unmarshalErr := dynamodbattribute.UnmarshalMap(result.Item, existingAcc)
 			if unmarshalErr != nil {
-				err = unmarshalErr
-				break
+				return nil, nil, unmarshalErr
 			}
 			var breaches []string
-			if contains(existingAcc.Breaches, breachName) {
+			if account.Contains(existingAcc.Breaches, breachName) {
 				breaches = existingAcc.Breaches
 			} else {
 				breaches = append(existingAcc.Breaches, breachName)
 			}
-			account.Breaches = breaches
+			acc.Breaches = breaches
 		} else {
-			account.Breaches = []string{breachName}
+			acc.Breaches = []string{breachName}
 		}
-		accs = append(accs, account)
+		done = append(done, acc)
 	}
-	if err != nil {
-		return []Account{}, err
+	return done, nil, nil
+}
+
+// accountNames extracts the Account identifier of each record.
+func accountNames(accounts []account.Account) []string {
+	names := make([]string, 0, len(accounts))
+	for _, acc := range accounts {
+		names = append(names, acc.Account)
 	}
-	return accs, nil
+	return names
 }
 
-func marshalMapToAttributeValues(accounts []Account) ([]map[string]*dynamodb.AttributeValue, error) {
+// subtract returns the names in all that aren't present in minus.
+func subtract(all []string, minus []string) []string {
+	excluded := make(map[string]bool, len(minus))
+	for _, name := range minus {
+		excluded[name] = true
+	}
+	remaining := make([]string, 0, len(all))
+	for _, name := range all {
+		if !excluded[name] {
+			remaining = append(remaining, name)
+		}
+	}
+	return remaining
+}
+
+// deadlineResponse reports a 504 listing which accounts finished before ctx
+// was cancelled or its deadline arrived, so the client can resume the
+// upload with just the accounts still pending instead of retrying
+// everything.
+func deadlineResponse(completed []string, pending []string) Response {
+	body, _ := json.Marshal(map[string]interface{}{
+		"message":   "Timed out before finishing; resume with the pending accounts.",
+		"completed": completed,
+		"pending":   pending,
+	})
+	return Response{
+		StatusCode: 504,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+}
+
+// dedupeAccounts collapses accounts sharing a PK+SK down to the last
+// occurrence, since DynamoDB's BatchWriteItem rejects an entire 25-item
+// chunk outright if the same PK+SK appears in it twice - plausible for a
+// bulk breach-dump upload with repeated rows. The last occurrence wins so
+// its (already-merged) Breaches list is what gets written.
+func dedupeAccounts(accounts []account.Account) []account.Account {
+	index := make(map[string]int, len(accounts))
+	deduped := make([]account.Account, 0, len(accounts))
+	for _, acc := range accounts {
+		key := acc.PK + "#" + acc.SK
+		if i, ok := index[key]; ok {
+			deduped[i] = acc
+			continue
+		}
+		index[key] = len(deduped)
+		deduped = append(deduped, acc)
+	}
+	return deduped
+}
+
+func marshalMapToAttributeValues(accounts []account.Account) ([]map[string]*dynamodb.AttributeValue, error) {
 	attrVals := make([]map[string]*dynamodb.AttributeValue, 0, len(accounts))
 	var err error
 
-	for _, account := range accounts {
-		attrVal, marshalErr := dynamodbattribute.MarshalMap(account)
+	for _, acc := range accounts {
+		attrVal, marshalErr := dynamodbattribute.MarshalMap(acc)
 		if err != nil {
 			err = marshalErr
 			break
@@ -183,41 +254,132 @@ func marshalMapToAttributeValues(accounts []Account) ([]map[string]*dynamodb.Att
 	return attrVals, nil
 }
 
-var emailRegex = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+// batchPutAccounts writes attrVals to tableName in chunks of
+// dynamoBatchWriteLimit, spread across a bounded worker pool, retrying
+// UnprocessedItems with exponential backoff and jitter. It returns the
+// Account values that could not be written after exhausting retries rather
+// than aborting on the first error.
+func batchPutAccounts(ctx context.Context, attrVals []map[string]*dynamodb.AttributeValue) ([]string, error) {
+	chunks := chunkAttributeValues(attrVals, dynamoBatchWriteLimit)
 
-type Email struct {
-	Domain string
-	Alias  string
-}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		failed   []string
+		firstErr error
+	)
+
+	chunkCh := make(chan []map[string]*dynamodb.AttributeValue)
+	workers := batchWorkerCount()
+	if workers > len(chunks) {
+		workers = len(chunks)
+	}
 
-func NewEmail(emailStr string) (Email, error) {
-	if !emailRegex.MatchString(emailStr) {
-		return Email{}, fmt.Errorf("not a valid email address: %s", emailStr)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range chunkCh {
+				chunkFailed, err := putChunkWithRetry(ctx, chunk)
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				failed = append(failed, chunkFailed...)
+				mu.Unlock()
+			}
+		}()
 	}
-	email := strings.Split(emailStr, "@")
-	return Email{
-		Alias:  email[0],
-		Domain: email[1],
-	}, nil
+
+	for _, chunk := range chunks {
+		chunkCh <- chunk
+	}
+	close(chunkCh)
+	wg.Wait()
+
+	return failed, firstErr
 }
 
-func (e Email) Account() string {
-	return fmt.Sprintf("%s@%s", e.Alias, e.Domain)
+func chunkAttributeValues(attrVals []map[string]*dynamodb.AttributeValue, size int) [][]map[string]*dynamodb.AttributeValue {
+	chunks := make([][]map[string]*dynamodb.AttributeValue, 0, (len(attrVals)+size-1)/size)
+	for size < len(attrVals) {
+		attrVals, chunks = attrVals[size:], append(chunks, attrVals[0:size:size])
+	}
+	if len(attrVals) > 0 {
+		chunks = append(chunks, attrVals)
+	}
+	return chunks
 }
 
-func (e Email) PartitionKey() string {
-	return fmt.Sprintf("EMAIL#%s", e.Domain)
+// putChunkWithRetry writes a single BatchWriteItem-sized chunk, resubmitting
+// UnprocessedItems with exponential backoff and jitter until they succeed,
+// maxUnprocessedRetries is exhausted, or ctx is done. It returns the Account
+// identifiers that were still unprocessed when it gave up.
+func putChunkWithRetry(ctx context.Context, chunk []map[string]*dynamodb.AttributeValue) ([]string, error) {
+	requests := writeRequestsFor(chunk)
+
+	for attempt := 0; attempt < maxUnprocessedRetries && len(requests) > 0; attempt++ {
+		if attempt > 0 {
+			if err := sleepWithBackoff(ctx, attempt); err != nil {
+				return accountsFor(requests), nil
+			}
+		}
+
+		input := &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]*dynamodb.WriteRequest{
+				tableName: requests,
+			},
+		}
+		result, err := svc.BatchWriteItemWithContext(ctx, input)
+		if err != nil {
+			return accountsFor(requests), err
+		}
+
+		requests = result.UnprocessedItems[tableName]
+	}
+
+	return accountsFor(requests), nil
 }
 
-func (e Email) SortKey() string {
-	return fmt.Sprintf("EMAIL#%s", e.Alias)
+func writeRequestsFor(attrVals []map[string]*dynamodb.AttributeValue) []*dynamodb.WriteRequest {
+	requests := make([]*dynamodb.WriteRequest, 0, len(attrVals))
+	for _, attrVal := range attrVals {
+		requests = append(requests, &dynamodb.WriteRequest{
+			PutRequest: &dynamodb.PutRequest{Item: attrVal},
+		})
+	}
+	return requests
 }
 
-func contains(arr []string, str string) bool {
-	for _, el := range arr {
-		if el == str {
-			return true
+func accountsFor(requests []*dynamodb.WriteRequest) []string {
+	accounts := make([]string, 0, len(requests))
+	for _, req := range requests {
+		if req.PutRequest == nil || req.PutRequest.Item == nil {
+			continue
+		}
+		if accountAttr, ok := req.PutRequest.Item["Account"]; ok && accountAttr.S != nil {
+			accounts = append(accounts, *accountAttr.S)
 		}
 	}
-	return false
+	return accounts
+}
+
+// sleepWithBackoff waits for an exponential-with-jitter delay before the
+// next UnprocessedItems retry, returning early with an error if ctx is
+// cancelled or its deadline arrives first.
+func sleepWithBackoff(ctx context.Context, attempt int) error {
+	base := 50 * time.Millisecond
+	maxDelay := 2 * time.Second
+	delay := base * time.Duration(1<<uint(attempt))
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	jittered := time.Duration(rand.Int63n(int64(delay)))
+
+	select {
+	case <-time.After(jittered):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
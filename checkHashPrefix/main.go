@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+
+	"github.com/joshuous/haveibeenbreached/account"
+)
+
+type Response events.APIGatewayProxyResponse
+
+type CheckHashPrefixEvent struct {
+	PathParameters struct {
+		Prefix string
+	}
+}
+
+var sess = session.Must(session.NewSessionWithOptions(session.Options{
+	SharedConfigState: session.SharedConfigEnable,
+}))
+var svc = dynamodb.New(sess)
+
+// Handler implements an HIBP-style k-anonymity range lookup: given the
+// first account.HashPrefixLength hex characters of the caller's SHA1Hash,
+// it returns every matching suffix together with the breach names the
+// corresponding account appears in, so the full identifier never leaves
+// the caller.
+func Handler(ctx context.Context, event CheckHashPrefixEvent) (Response, error) {
+	ctx, cancel := account.DeadlineContext(ctx)
+	defer cancel()
+
+	prefix := strings.ToUpper(event.PathParameters.Prefix)
+	if len(prefix) != account.HashPrefixLength {
+		return Response{StatusCode: 400, Body: fmt.Sprintf("prefix must be %d hex characters", account.HashPrefixLength)}, fmt.Errorf("invalid prefix: %s", prefix)
+	}
+
+	matches, err := queryHashPrefix(ctx, prefix)
+	if err != nil {
+		if ctx.Err() != nil {
+			return Response{StatusCode: 504, Body: "Timed out looking up hash prefix"}, nil
+		}
+		return Response{StatusCode: 400, Body: fmt.Sprintf("Error querying hash prefix: %s", err)}, err
+	}
+
+	body, err := json.Marshal(matches)
+	if err != nil {
+		return Response{StatusCode: 400}, err
+	}
+
+	return Response{
+		StatusCode:      200,
+		IsBase64Encoded: false,
+		Body:            string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}, nil
+}
+
+func main() {
+	lambda.Start(Handler)
+}
+
+// hashPrefixMatch is one suffix/breaches pair for a queried hash prefix,
+// mirroring the HIBP range response but as structured JSON so a BreachName
+// containing "," or ":" still round-trips correctly.
+type hashPrefixMatch struct {
+	Suffix   string   `json:"suffix"`
+	Breaches []string `json:"breaches"`
+}
+
+// queryHashPrefix pages through account.HashPrefixIndexName for the given
+// prefix and returns each match's suffix alongside the breach names the
+// corresponding account appears in.
+func queryHashPrefix(ctx context.Context, prefix string) ([]hashPrefixMatch, error) {
+	matches := make([]hashPrefixMatch, 0)
+	var exclusiveStartKey map[string]*dynamodb.AttributeValue
+
+	for {
+		input := &dynamodb.QueryInput{
+			TableName:              aws.String(account.TableName),
+			IndexName:              aws.String(account.HashPrefixIndexName),
+			KeyConditionExpression: aws.String("HashPrefix = :prefix"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":prefix": {S: aws.String(prefix)},
+			},
+			ExclusiveStartKey: exclusiveStartKey,
+		}
+
+		result, err := svc.QueryWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range result.Items {
+			acc := &account.Account{}
+			if err := dynamodbattribute.UnmarshalMap(item, acc); err != nil {
+				return nil, err
+			}
+			suffix := acc.SHA1Hash[len(prefix):]
+			matches = append(matches, hashPrefixMatch{Suffix: suffix, Breaches: acc.Breaches})
+		}
+
+		if result.LastEvaluatedKey == nil {
+			break
+		}
+		exclusiveStartKey = result.LastEvaluatedKey
+	}
+
+	return matches, nil
+}
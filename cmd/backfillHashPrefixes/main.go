@@ -0,0 +1,90 @@
+// Command backfillHashPrefixes is a one-off migration that scans every
+// existing EMAIL# item in the Breaches table and populates the SHA1Hash and
+// HashPrefix attributes added for the k-anonymity range lookup, so the new
+// GSI is populated for accounts ingested before it existed.
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+
+	"github.com/joshuous/haveibeenbreached/account"
+)
+
+var sess = session.Must(session.NewSessionWithOptions(session.Options{
+	SharedConfigState: session.SharedConfigEnable,
+}))
+var svc = dynamodb.New(sess)
+
+func main() {
+	updated, err := backfill()
+	if err != nil {
+		log.Fatalf("backfill failed after updating %d accounts: %s", updated, err)
+	}
+	log.Printf("backfilled SHA1Hash/HashPrefix for %d accounts", updated)
+}
+
+func backfill() (int, error) {
+	updated := 0
+	var exclusiveStartKey map[string]*dynamodb.AttributeValue
+
+	for {
+		input := &dynamodb.ScanInput{
+			TableName:        aws.String(account.TableName),
+			FilterExpression: aws.String("begins_with(PK, :emailPrefix)"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":emailPrefix": {S: aws.String("EMAIL#")},
+			},
+			ExclusiveStartKey: exclusiveStartKey,
+		}
+
+		result, err := svc.Scan(input)
+		if err != nil {
+			return updated, err
+		}
+
+		for _, item := range result.Items {
+			acc := &account.Account{}
+			if err := dynamodbattribute.UnmarshalMap(item, acc); err != nil {
+				return updated, err
+			}
+			if acc.SHA1Hash != "" {
+				continue
+			}
+			if err := backfillAccount(acc); err != nil {
+				return updated, fmt.Errorf("account %s: %w", acc.Account, err)
+			}
+			updated++
+		}
+
+		if result.LastEvaluatedKey == nil {
+			break
+		}
+		exclusiveStartKey = result.LastEvaluatedKey
+	}
+
+	return updated, nil
+}
+
+func backfillAccount(acc *account.Account) error {
+	hash, prefix := account.SHA1Hash(acc.Account)
+
+	_, err := svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(account.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {S: aws.String(acc.PK)},
+			"SK": {S: aws.String(acc.SK)},
+		},
+		UpdateExpression: aws.String("SET SHA1Hash = :hash, HashPrefix = :prefix"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":hash":   {S: aws.String(hash)},
+			":prefix": {S: aws.String(prefix)},
+		},
+	})
+	return err
+}